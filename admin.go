@@ -0,0 +1,65 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"log"
+	"net/http"
+	"strings"
+)
+
+var adminListen = flag.String("admin-listen", "", "address for the admin HTTP API (empty disables it)")
+
+// startAdmin starts the admin HTTP API on -admin-listen, if set,
+// serving /routes, /metrics, /healthz, and /connections.
+func startAdmin() {
+	if *adminListen == "" {
+		return
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/routes", handleRoutes)
+	mux.HandleFunc("/metrics", handleMetrics)
+	mux.HandleFunc("/healthz", handleHealthz)
+	mux.HandleFunc("/connections", handleConnections)
+
+	go func() {
+		if err := http.ListenAndServe(*adminListen, mux); err != nil {
+			log.Printf("admin listener on %q stopped: %s", *adminListen, err)
+		}
+	}()
+}
+
+// handleRoutes dumps the routing table currently in effect, for
+// operators debugging a reload.
+func handleRoutes(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(router.Snapshot()); err != nil {
+		log.Printf("encoding /routes response: %s", err)
+	}
+}
+
+// handleMetrics serves process metrics in Prometheus text exposition
+// format; see metrics.go.
+func handleMetrics(w http.ResponseWriter, r *http.Request) {
+	var b strings.Builder
+	writeMetrics(&b)
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	w.Write([]byte(b.String()))
+}
+
+// handleHealthz reports ok as long as the process is up and serving;
+// it does not depend on any backend being healthy, since that's what
+// takes tlsrouter out of a load balancer's rotation, not the reverse.
+func handleHealthz(w http.ResponseWriter, r *http.Request) {
+	w.Write([]byte("ok\n"))
+}
+
+// handleConnections dumps the connections currently being proxied, for
+// operators debugging a stuck or noisy client.
+func handleConnections(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(snapshotConns()); err != nil {
+		log.Printf("encoding /connections response: %s", err)
+	}
+}
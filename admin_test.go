@@ -0,0 +1,77 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestHandleHealthz(t *testing.T) {
+	rec := httptest.NewRecorder()
+	handleHealthz(rec, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	if rec.Code != http.StatusOK {
+		t.Errorf("status = %d, want %d", rec.Code, http.StatusOK)
+	}
+	if rec.Body.String() != "ok\n" {
+		t.Errorf("body = %q, want %q", rec.Body.String(), "ok\n")
+	}
+}
+
+func TestHandleMetrics(t *testing.T) {
+	rec := httptest.NewRecorder()
+	handleMetrics(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if rec.Code != http.StatusOK {
+		t.Errorf("status = %d, want %d", rec.Code, http.StatusOK)
+	}
+	if !strings.Contains(rec.Body.String(), "tlsrouter_connections_accepted_total") {
+		t.Errorf("body missing expected metric, got:\n%s", rec.Body.String())
+	}
+}
+
+func TestHandleConnections(t *testing.T) {
+	c := newTestConn(t)
+	registerConn(c)
+	defer unregisterConn(c)
+
+	rec := httptest.NewRecorder()
+	handleConnections(rec, httptest.NewRequest(http.MethodGet, "/connections", nil))
+	if rec.Code != http.StatusOK {
+		t.Errorf("status = %d, want %d", rec.Code, http.StatusOK)
+	}
+
+	var conns []connInfo
+	if err := json.Unmarshal(rec.Body.Bytes(), &conns); err != nil {
+		t.Fatalf("unmarshaling /connections response: %s", err)
+	}
+	found := false
+	for _, info := range conns {
+		if info.RemoteAddr == c.RemoteAddr().String() {
+			found = true
+		}
+	}
+	if !found {
+		t.Error("/connections response does not include the registered connection")
+	}
+}
+
+func TestHandleRoutes(t *testing.T) {
+	oldRouter := router
+	router = newRouter(&Config{routes: []Route{{pattern: "example.com", backends: []*Backend{newBackend("10.0.0.1:443", 1)}, picker: weightedRandomPicker{}}}})
+	defer func() { router = oldRouter }()
+
+	rec := httptest.NewRecorder()
+	handleRoutes(rec, httptest.NewRequest(http.MethodGet, "/routes", nil))
+	if rec.Code != http.StatusOK {
+		t.Errorf("status = %d, want %d", rec.Code, http.StatusOK)
+	}
+
+	var routes []RouteInfo
+	if err := json.Unmarshal(rec.Body.Bytes(), &routes); err != nil {
+		t.Fatalf("unmarshaling /routes response: %s", err)
+	}
+	if len(routes) != 1 || routes[0].Pattern != "example.com" {
+		t.Errorf("routes = %+v, want one route for example.com", routes)
+	}
+}
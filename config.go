@@ -0,0 +1,267 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Route is a single parsed line from the config file: a hostname
+// pattern (and, optionally, a set of ALPN protocols) mapped to one or
+// more weighted backends, plus any options for those backends.
+type Route struct {
+	pattern  string
+	backends []*Backend
+	picker   Picker
+
+	// alpn, if non-empty, restricts this route to ClientHellos that
+	// offer at least one of these ALPN protocols. A route with no
+	// alpn matches regardless of what the client offered, and only
+	// applies if no ALPN-specific route for the same pattern matched
+	// first; this lets *.example.com split h2 and http/1.1 to
+	// different backends while still falling back for anything else.
+	alpn []string
+
+	// sendProxy controls whether a PROXY protocol header is prepended
+	// before the buffered ClientHello is replayed to the chosen
+	// backend.
+	sendProxy proxyProtoVersion
+}
+
+// Config holds the routing table loaded from the configuration file.
+// It is safe for concurrent use; ReadFile atomically swaps in a new
+// table built from the file's current contents.
+type Config struct {
+	mu     sync.Mutex
+	routes []Route
+}
+
+// ReadFile parses path and atomically replaces the current routing
+// table. Each non-blank, non-comment line has the form:
+//
+//	pattern[@alpn1,alpn2,...] backend1[:weight] backend2[:weight] ... [options]
+//
+// pattern is a hostname, optionally prefixed with "*." to match any
+// subdomain, and optionally suffixed with "@" and a comma-separated
+// list of ALPN protocol names (e.g. "*.example.com@h2") to further
+// restrict the route to ClientHellos offering one of them - so h2 and
+// http/1.1 traffic for the same hostname can go to different
+// backends. Each backend is a "host:port" address, optionally
+// suffixed with ":weight" (default 1) to bias weighted-random
+// selection among them. Recognised options, mirroring HAProxy's
+// model, are "send-proxy"/"send-proxy-v2" (prepend a PROXY protocol
+// header before replaying the buffered ClientHello) and
+// "round-robin"/"sticky" (pick among backends in round-robin order,
+// or stickily by SNI, instead of the default weighted-random).
+func (c *Config) ReadFile(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	var routes []Route
+	scanner := bufio.NewScanner(f)
+	for lineNum := 1; scanner.Scan(); lineNum++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			return fmt.Errorf("%s:%d: expected \"pattern backend1[:weight] ...\", got %q", path, lineNum, line)
+		}
+		route, err := parseRouteFields(fields)
+		if err != nil {
+			return fmt.Errorf("%s:%d: %s", path, lineNum, err)
+		}
+		routes = append(routes, route)
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	c.routes = routes
+	c.mu.Unlock()
+	return nil
+}
+
+// parseRouteFields builds a Route from a config line already split on
+// whitespace: fields[0] is the pattern, fields[1:] are backends and
+// options in any order. It's shared by the file parser above and by
+// the dynamic config sources in configsource.go, all of which use the
+// same grammar for a route's right-hand side.
+func parseRouteFields(fields []string) (Route, error) {
+	pattern, alpn := splitPatternALPN(fields[0])
+	route := Route{pattern: pattern, alpn: alpn, picker: weightedRandomPicker{}}
+	for _, field := range fields[1:] {
+		switch field {
+		case "send-proxy", "send-proxy-v2":
+			sendProxy, err := parseProxyProtoVersion(field)
+			if err != nil {
+				return Route{}, err
+			}
+			route.sendProxy = sendProxy
+		case "round-robin":
+			route.picker = &roundRobinPicker{}
+		case "sticky":
+			route.picker = sniStickyPicker{}
+		default:
+			backend, err := parseBackendSpec(field)
+			if err != nil {
+				return Route{}, err
+			}
+			route.backends = append(route.backends, backend)
+		}
+	}
+	if len(route.backends) == 0 {
+		return Route{}, fmt.Errorf("route for %q has no backends", route.pattern)
+	}
+	return route, nil
+}
+
+// splitPatternALPN splits a route's left-hand field into its hostname
+// pattern and, if present after an "@", its restricted ALPN protocol
+// list.
+func splitPatternALPN(field string) (pattern string, alpn []string) {
+	i := strings.IndexByte(field, '@')
+	if i < 0 {
+		return field, nil
+	}
+	return field[:i], strings.Split(field[i+1:], ",")
+}
+
+// parseBackendSpec parses a single "host:port" or "host:port:weight"
+// field from a route line.
+func parseBackendSpec(field string) (*Backend, error) {
+	parts := strings.Split(field, ":")
+	switch len(parts) {
+	case 2:
+		return newBackend(field, 1), nil
+	case 3:
+		weight, err := strconv.Atoi(parts[2])
+		if err != nil || weight <= 0 {
+			return nil, fmt.Errorf("invalid weight in backend %q", field)
+		}
+		return newBackend(parts[0]+":"+parts[1], weight), nil
+	default:
+		return nil, fmt.Errorf("invalid backend %q, expected host:port or host:port:weight", field)
+	}
+}
+
+// Match returns the best route for a ClientHello with the given SNI
+// hostname and offered ALPN protocols, or nil if none matches. Within
+// routes for the same hostname, one restricted to an offered ALPN
+// protocol wins over an unrestricted route, so routes can be listed
+// most-specific first or in any order. But an exact-hostname match
+// always outranks a wildcard match regardless of ALPN, so a literal
+// "app.example.com" route is never silently overridden by, say, a
+// later "*.example.com@h2" route just because the client happens to
+// offer h2 - wildcard/ALPN scoping only breaks ties among routes of
+// the same hostname specificity, it doesn't override it.
+func (c *Config) Match(hostname string, alpnProtos []string) *Route {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if r := matchTier(c.routes, hostname, alpnProtos, true); r != nil {
+		return r
+	}
+	return matchTier(c.routes, hostname, alpnProtos, false)
+}
+
+// matchTier scans routes for hostname, considering only exact pattern
+// matches when exactOnly is set and only wildcard pattern matches
+// otherwise, returning the first ALPN-restricted match (if the client
+// offered one of its protocols) or else the first unrestricted
+// fallback, in list order.
+func matchTier(routes []Route, hostname string, alpnProtos []string, exactOnly bool) *Route {
+	var fallback *Route
+	for i := range routes {
+		r := &routes[i]
+		if !hostnameMatches(r.pattern, hostname) {
+			continue
+		}
+		if (r.pattern == hostname) != exactOnly {
+			continue
+		}
+		if len(r.alpn) == 0 {
+			if fallback == nil {
+				fallback = r
+			}
+			continue
+		}
+		if alpnIntersects(r.alpn, alpnProtos) {
+			return r
+		}
+	}
+	return fallback
+}
+
+func hostnameMatches(pattern, hostname string) bool {
+	if pattern == hostname {
+		return true
+	}
+	return strings.HasPrefix(pattern, "*.") && strings.HasSuffix(hostname, pattern[1:])
+}
+
+func alpnIntersects(routeALPN, offered []string) bool {
+	for _, want := range routeALPN {
+		for _, got := range offered {
+			if want == got {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// RouteInfo is a JSON-friendly snapshot of a Route, for the admin
+// /routes endpoint.
+type RouteInfo struct {
+	Pattern   string   `json:"pattern"`
+	ALPN      []string `json:"alpn,omitempty"`
+	Backends  []string `json:"backends"`
+	SendProxy string   `json:"send_proxy,omitempty"`
+}
+
+// Snapshot returns a JSON-friendly copy of the current routing table.
+func (c *Config) Snapshot() []RouteInfo {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	routes := make([]RouteInfo, len(c.routes))
+	for i, r := range c.routes {
+		info := RouteInfo{Pattern: r.pattern, ALPN: r.alpn}
+		for _, b := range r.backends {
+			state := "down"
+			if b.Healthy() {
+				state = "up"
+			}
+			info.Backends = append(info.Backends, fmt.Sprintf("%s (%s, weight %d)", b.Addr, state, b.Weight))
+		}
+		switch r.sendProxy {
+		case proxyProtoV1:
+			info.SendProxy = "send-proxy"
+		case proxyProtoV2:
+			info.SendProxy = "send-proxy-v2"
+		}
+		routes[i] = info
+	}
+	return routes
+}
+
+// healthyBackends returns the subset of the route's backends that are
+// currently considered up.
+func (r *Route) healthyBackends() []*Backend {
+	healthy := make([]*Backend, 0, len(r.backends))
+	for _, b := range r.backends {
+		if b.Healthy() {
+			healthy = append(healthy, b)
+		}
+	}
+	return healthy
+}
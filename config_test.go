@@ -0,0 +1,121 @@
+package main
+
+import "testing"
+
+func TestParseBackendSpec(t *testing.T) {
+	cases := []struct {
+		field      string
+		wantAddr   string
+		wantWeight int
+		wantErr    bool
+	}{
+		{field: "10.0.0.1:443", wantAddr: "10.0.0.1:443", wantWeight: 1},
+		{field: "10.0.0.1:443:5", wantAddr: "10.0.0.1:443", wantWeight: 5},
+		{field: "10.0.0.1:443:0", wantErr: true},
+		{field: "10.0.0.1:443:-1", wantErr: true},
+		{field: "10.0.0.1:443:notanumber", wantErr: true},
+		{field: "10.0.0.1", wantErr: true},
+		{field: "10.0.0.1:443:5:extra", wantErr: true},
+	}
+	for _, c := range cases {
+		b, err := parseBackendSpec(c.field)
+		if c.wantErr {
+			if err == nil {
+				t.Errorf("parseBackendSpec(%q): expected error, got backend %+v", c.field, b)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parseBackendSpec(%q): unexpected error: %s", c.field, err)
+			continue
+		}
+		if b.Addr != c.wantAddr || b.Weight != c.wantWeight {
+			t.Errorf("parseBackendSpec(%q) = {%s, %d}, want {%s, %d}", c.field, b.Addr, b.Weight, c.wantAddr, c.wantWeight)
+		}
+	}
+}
+
+func TestParseRouteFields(t *testing.T) {
+	route, err := parseRouteFields([]string{"*.example.com@h2,http/1.1", "10.0.0.1:443:2", "10.0.0.2:443", "send-proxy-v2", "round-robin"})
+	if err != nil {
+		t.Fatalf("parseRouteFields: %s", err)
+	}
+	if route.pattern != "*.example.com" {
+		t.Errorf("pattern = %q, want %q", route.pattern, "*.example.com")
+	}
+	if len(route.alpn) != 2 || route.alpn[0] != "h2" || route.alpn[1] != "http/1.1" {
+		t.Errorf("alpn = %v, want [h2 http/1.1]", route.alpn)
+	}
+	if len(route.backends) != 2 {
+		t.Fatalf("backends = %v, want 2 entries", route.backends)
+	}
+	if route.sendProxy != proxyProtoV2 {
+		t.Errorf("sendProxy = %v, want proxyProtoV2", route.sendProxy)
+	}
+	if _, ok := route.picker.(*roundRobinPicker); !ok {
+		t.Errorf("picker = %T, want *roundRobinPicker", route.picker)
+	}
+}
+
+func TestParseRouteFieldsNoBackends(t *testing.T) {
+	if _, err := parseRouteFields([]string{"example.com", "send-proxy"}); err == nil {
+		t.Error("parseRouteFields: expected error for route with no backends, got nil")
+	}
+}
+
+func TestParseRouteFieldsInvalidBackend(t *testing.T) {
+	if _, err := parseRouteFields([]string{"example.com", "not-a-backend"}); err == nil {
+		t.Error("parseRouteFields: expected error for malformed backend, got nil")
+	}
+}
+
+func TestConfigMatchExactBeatsWildcardALPN(t *testing.T) {
+	exact := Route{pattern: "app.example.com", backends: []*Backend{newBackend("10.0.0.1:443", 1)}, picker: weightedRandomPicker{}}
+	wildcardH2 := Route{pattern: "*.example.com", alpn: []string{"h2"}, backends: []*Backend{newBackend("10.0.0.2:443", 1)}, picker: weightedRandomPicker{}}
+	c := &Config{routes: []Route{exact, wildcardH2}}
+
+	// Even though the client offers h2 (which the wildcard route
+	// restricts on), the exact hostname match must still win.
+	got := c.Match("app.example.com", []string{"h2"})
+	if got == nil || got.pattern != "app.example.com" {
+		t.Fatalf("Match = %v, want the exact app.example.com route", got)
+	}
+
+	// A hostname with no exact route still falls through to the
+	// wildcard/ALPN route.
+	got = c.Match("other.example.com", []string{"h2"})
+	if got == nil || got.pattern != "*.example.com" {
+		t.Fatalf("Match = %v, want the wildcard *.example.com route", got)
+	}
+}
+
+func TestConfigMatchALPNBeatsFallbackWithinTier(t *testing.T) {
+	plain := Route{pattern: "*.example.com", backends: []*Backend{newBackend("10.0.0.1:443", 1)}, picker: weightedRandomPicker{}}
+	h2 := Route{pattern: "*.example.com", alpn: []string{"h2"}, backends: []*Backend{newBackend("10.0.0.2:443", 1)}, picker: weightedRandomPicker{}}
+	c := &Config{routes: []Route{plain, h2}}
+
+	if got := c.Match("www.example.com", []string{"h2"}); got == nil || got.backends[0].Addr != "10.0.0.2:443" {
+		t.Fatalf("Match with ALPN h2 = %v, want the h2-restricted route", got)
+	}
+	if got := c.Match("www.example.com", []string{"http/1.1"}); got == nil || got.backends[0].Addr != "10.0.0.1:443" {
+		t.Fatalf("Match with ALPN http/1.1 = %v, want the unrestricted fallback route", got)
+	}
+}
+
+func TestHostnameMatches(t *testing.T) {
+	cases := []struct {
+		pattern, hostname string
+		want              bool
+	}{
+		{"example.com", "example.com", true},
+		{"example.com", "www.example.com", false},
+		{"*.example.com", "www.example.com", true},
+		{"*.example.com", "example.com", false},
+		{"*.example.com", "evilexample.com", false},
+	}
+	for _, c := range cases {
+		if got := hostnameMatches(c.pattern, c.hostname); got != c.want {
+			t.Errorf("hostnameMatches(%q, %q) = %v, want %v", c.pattern, c.hostname, got, c.want)
+		}
+	}
+}
@@ -0,0 +1,359 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+)
+
+var confSource = flag.String("conf-source", "file", "where to load the routing table from: \"file\", \"http\", \"consul\", or \"etcd\"")
+var confSourceURL = flag.String("conf-source-url", "", "URL for -conf-source=http (route table endpoint), consul (agent base URL), or etcd (JSON gateway base URL)")
+var confKVPrefix = flag.String("conf-kv-prefix", "tlsrouter/routes/", "key prefix for -conf-source=consul or etcd; each key's final path segment is a hostname pattern, its value a route line")
+
+// startConfigSource launches whichever -conf-source selects as a
+// background goroutine that feeds newly parsed routing tables to
+// router for the life of the process, and installs a SIGHUP handler
+// that asks it to refresh immediately as a manual fallback.
+func startConfigSource(router *Router) {
+	reload := make(chan struct{}, 1)
+	watchSIGHUP(reload)
+
+	switch *confSource {
+	case "", "file":
+		go watchFile(*cfgFile, router, reload)
+	case "http":
+		go watchHTTP(*confSourceURL, router, reload)
+	case "consul":
+		go watchKV(consulKV{base: *confSourceURL}, *confKVPrefix, router, reload)
+	case "etcd":
+		go watchKV(etcdKV{base: *confSourceURL}, *confKVPrefix, router, reload)
+	default:
+		log.Fatalf("unknown -conf-source %q", *confSource)
+	}
+}
+
+// watchSIGHUP arranges for a SIGHUP to push a non-blocking
+// notification onto reload, for operators who'd rather signal a
+// reload than wait for the configured source to notice one (or
+// whose source, like a plain file, has no push notification at all).
+func watchSIGHUP(reload chan<- struct{}) {
+	sighup := make(chan os.Signal, 1)
+	signal.Notify(sighup, syscall.SIGHUP)
+	go func() {
+		for range sighup {
+			select {
+			case reload <- struct{}{}:
+			default:
+			}
+		}
+	}()
+}
+
+// watchFile polls path's mtime for changes, re-parsing and installing
+// a new routing table whenever it advances. True filesystem
+// notifications would save the polling, but would also be the only
+// external dependency in an otherwise dependency-free binary, so a
+// cheap poll loop (nudged along by reload, e.g. on SIGHUP) is what
+// tlsrouter uses instead.
+func watchFile(path string, router *Router, reload <-chan struct{}) {
+	var lastMod time.Time
+	if fi, err := os.Stat(path); err == nil {
+		lastMod = fi.ModTime()
+	}
+
+	ticker := time.NewTicker(2 * time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-reload:
+		}
+
+		fi, err := os.Stat(path)
+		if err != nil {
+			log.Printf("config file %q: %s", path, err)
+			continue
+		}
+		if !fi.ModTime().After(lastMod) {
+			continue
+		}
+
+		c := &Config{}
+		if err := c.ReadFile(path); err != nil {
+			log.Printf("reloading config %q: %s", path, err)
+			continue
+		}
+		lastMod = fi.ModTime()
+		router.set(c)
+		log.Printf("reloaded routing table from %q", path)
+	}
+}
+
+// routeTableJSON is the wire format watchHTTP and the KV sources both
+// parse: hostname pattern -> the same "backend1[:weight] ...
+// [options]" grammar used in the config file, so all four sources
+// share one grammar.
+type routeTableJSON map[string]string
+
+func buildConfig(table routeTableJSON) (*Config, error) {
+	c := &Config{}
+	var routes []Route
+	for pattern, rest := range table {
+		fields := append([]string{pattern}, strings.Fields(rest)...)
+		route, err := parseRouteFields(fields)
+		if err != nil {
+			return nil, fmt.Errorf("route %q: %s", pattern, err)
+		}
+		routes = append(routes, route)
+	}
+	c.routes = routes
+	return c, nil
+}
+
+// watchHTTP long-polls confURL for a JSON route table, applying it
+// whenever the fetch succeeds and its content changed.
+func watchHTTP(confURL string, router *Router, reload <-chan struct{}) {
+	client := &http.Client{Timeout: 65 * time.Second}
+	var lastBody string
+
+	ticker := time.NewTicker(30 * time.Second)
+	defer ticker.Stop()
+	for {
+		// A long read timeout plus a wait hint lets a cooperative
+		// server hold the request open until something changes
+		// (long-poll); an uncooperative one just answers immediately
+		// and we fall back to polling every tick.
+		pollURL, err := addWaitParam(confURL, "60s")
+		if err != nil {
+			log.Printf("parsing -conf-source-url %q: %s", confURL, err)
+			select {
+			case <-ticker.C:
+			case <-reload:
+			}
+			continue
+		}
+
+		resp, err := client.Get(pollURL)
+		if err != nil {
+			log.Printf("fetching routes from %q: %s", confURL, err)
+		} else {
+			body, err := readAndClose(resp)
+			if err != nil {
+				log.Printf("reading routes from %q: %s", confURL, err)
+			} else if body != lastBody {
+				var table routeTableJSON
+				if err := json.Unmarshal([]byte(body), &table); err != nil {
+					log.Printf("parsing routes from %q: %s", confURL, err)
+				} else if c, err := buildConfig(table); err != nil {
+					log.Printf("building routes from %q: %s", confURL, err)
+				} else {
+					router.set(c)
+					lastBody = body
+					log.Printf("reloaded routing table from %q", confURL)
+				}
+			}
+		}
+
+		select {
+		case <-ticker.C:
+		case <-reload:
+		}
+	}
+}
+
+// kvBackend abstracts the parts of Consul's and etcd's HTTP APIs that
+// watchKV needs: list everything under a prefix, and (ideally) block
+// until something under it changes.
+type kvBackend interface {
+	// list returns the current key -> value pairs under prefix.
+	list(prefix string) (map[string]string, error)
+	// name identifies the backend in log messages.
+	name() string
+}
+
+// watchKV polls (or, for backends that support it, blocks on) a KV
+// store's key prefix and applies it as a routing table whenever it
+// changes. Each key's final "/"-separated path segment is a hostname
+// pattern; its value is a route line in the same grammar as the
+// config file.
+func watchKV(kv kvBackend, prefix string, router *Router, reload <-chan struct{}) {
+	var lastTable map[string]string
+
+	ticker := time.NewTicker(5 * time.Second)
+	defer ticker.Stop()
+	for {
+		kvPairs, err := kv.list(prefix)
+		if err != nil {
+			log.Printf("listing routes from %s: %s", kv.name(), err)
+		} else if !sameTable(kvPairs, lastTable) {
+			table := make(routeTableJSON, len(kvPairs))
+			for key, value := range kvPairs {
+				pattern := key
+				if i := strings.LastIndexByte(key, '/'); i >= 0 {
+					pattern = key[i+1:]
+				}
+				table[pattern] = value
+			}
+			if c, err := buildConfig(table); err != nil {
+				log.Printf("building routes from %s: %s", kv.name(), err)
+			} else {
+				router.set(c)
+				lastTable = kvPairs
+				log.Printf("reloaded routing table from %s", kv.name())
+			}
+		}
+
+		select {
+		case <-ticker.C:
+		case <-reload:
+		}
+	}
+}
+
+// addWaitParam adds a "wait" query parameter to rawURL, merging it
+// with any query string rawURL already has (e.g. an auth token)
+// instead of blindly appending "?wait=...", which would produce a
+// malformed URL if one was already present.
+func addWaitParam(rawURL, wait string) (string, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "", err
+	}
+	q := u.Query()
+	q.Set("wait", wait)
+	u.RawQuery = q.Encode()
+	return u.String(), nil
+}
+
+func sameTable(a, b map[string]string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for k, v := range a {
+		if b[k] != v {
+			return false
+		}
+	}
+	return true
+}
+
+func readAndClose(resp *http.Response) (string, error) {
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	return string(body), err
+}
+
+// consulKV implements kvBackend against Consul's HTTP KV API
+// (https://developer.hashicorp.com/consul/api-docs/kv), which uses
+// long-polling blocking queries natively - no extra client needed.
+type consulKV struct{ base string }
+
+func (c consulKV) name() string { return fmt.Sprintf("consul %s", c.base) }
+
+func (c consulKV) list(prefix string) (map[string]string, error) {
+	u := fmt.Sprintf("%s/v1/kv/%s?recurse=true&wait=30s", strings.TrimRight(c.base, "/"), url.PathEscape(prefix))
+	resp, err := http.Get(u)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return map[string]string{}, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("consul returned %s", resp.Status)
+	}
+
+	var entries []struct {
+		Key   string
+		Value string // base64-encoded
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&entries); err != nil {
+		return nil, err
+	}
+
+	out := make(map[string]string, len(entries))
+	for _, e := range entries {
+		value, err := base64.StdEncoding.DecodeString(e.Value)
+		if err != nil {
+			return nil, fmt.Errorf("decoding value for key %q: %s", e.Key, err)
+		}
+		out[e.Key] = string(value)
+	}
+	return out, nil
+}
+
+// etcdKV implements kvBackend against etcd v3's JSON gRPC-gateway
+// (https://etcd.io/docs/v3/dev-guide/api_grpc_gateway/), polling
+// rather than using etcd's native watch, which needs either gRPC or a
+// streaming HTTP client this dependency-free binary doesn't have.
+type etcdKV struct{ base string }
+
+func (e etcdKV) name() string { return fmt.Sprintf("etcd %s", e.base) }
+
+func (e etcdKV) list(prefix string) (map[string]string, error) {
+	rangeEnd := prefixRangeEnd(prefix)
+	body, err := json.Marshal(map[string]string{
+		"key":       base64.StdEncoding.EncodeToString([]byte(prefix)),
+		"range_end": base64.StdEncoding.EncodeToString([]byte(rangeEnd)),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := http.Post(strings.TrimRight(e.base, "/")+"/v3/kv/range", "application/json", strings.NewReader(string(body)))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("etcd returned %s", resp.Status)
+	}
+
+	var result struct {
+		Kvs []struct {
+			Key   string
+			Value string
+		}
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, err
+	}
+
+	out := make(map[string]string, len(result.Kvs))
+	for _, kv := range result.Kvs {
+		key, err := base64.StdEncoding.DecodeString(kv.Key)
+		if err != nil {
+			return nil, fmt.Errorf("decoding key: %s", err)
+		}
+		value, err := base64.StdEncoding.DecodeString(kv.Value)
+		if err != nil {
+			return nil, fmt.Errorf("decoding value for key %q: %s", key, err)
+		}
+		out[string(key)] = string(value)
+	}
+	return out, nil
+}
+
+// prefixRangeEnd computes etcd's idiomatic "one past the last key
+// with this prefix" range end, by incrementing the prefix's last byte.
+func prefixRangeEnd(prefix string) string {
+	end := []byte(prefix)
+	for i := len(end) - 1; i >= 0; i-- {
+		if end[i] < 0xff {
+			end[i]++
+			return string(end[:i+1])
+		}
+	}
+	return "" // prefix was all 0xff bytes: unbounded range end
+}
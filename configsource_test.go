@@ -0,0 +1,93 @@
+package main
+
+import "testing"
+
+func TestBuildConfig(t *testing.T) {
+	table := routeTableJSON{
+		"app.example.com":  "10.0.0.1:443 10.0.0.2:443:2 send-proxy",
+		"*.example.com@h2": "10.0.0.3:443",
+	}
+	c, err := buildConfig(table)
+	if err != nil {
+		t.Fatalf("buildConfig: %s", err)
+	}
+	if len(c.routes) != 2 {
+		t.Fatalf("routes = %v, want 2 entries", c.routes)
+	}
+
+	route := c.Match("app.example.com", nil)
+	if route == nil {
+		t.Fatal("Match(app.example.com): no route")
+	}
+	if len(route.backends) != 2 || route.sendProxy != proxyProtoV1 {
+		t.Errorf("route = %+v, want 2 backends and send-proxy", route)
+	}
+}
+
+func TestBuildConfigInvalidRoute(t *testing.T) {
+	table := routeTableJSON{"app.example.com": "not-a-backend"}
+	if _, err := buildConfig(table); err == nil {
+		t.Error("buildConfig: expected error for malformed backend, got nil")
+	}
+}
+
+func TestBuildConfigNoBackends(t *testing.T) {
+	table := routeTableJSON{"app.example.com": ""}
+	if _, err := buildConfig(table); err == nil {
+		t.Error("buildConfig: expected error for route with no backends, got nil")
+	}
+}
+
+func TestSameTable(t *testing.T) {
+	cases := []struct {
+		a, b map[string]string
+		want bool
+	}{
+		{nil, nil, true},
+		{map[string]string{}, nil, true},
+		{map[string]string{"a": "1"}, map[string]string{"a": "1"}, true},
+		{map[string]string{"a": "1"}, map[string]string{"a": "2"}, false},
+		{map[string]string{"a": "1"}, map[string]string{"a": "1", "b": "2"}, false},
+		{map[string]string{"a": "1"}, map[string]string{"b": "1"}, false},
+	}
+	for _, c := range cases {
+		if got := sameTable(c.a, c.b); got != c.want {
+			t.Errorf("sameTable(%v, %v) = %v, want %v", c.a, c.b, got, c.want)
+		}
+	}
+}
+
+func TestPrefixRangeEnd(t *testing.T) {
+	cases := []struct {
+		prefix, want string
+	}{
+		{"tlsrouter/routes/", "tlsrouter/routes0"},
+		{"a", "b"},
+		{"", ""},
+		{string([]byte{0xff, 0xff}), ""},
+		{string([]byte{0x01, 0xff}), string([]byte{0x02})},
+	}
+	for _, c := range cases {
+		if got := prefixRangeEnd(c.prefix); got != c.want {
+			t.Errorf("prefixRangeEnd(%q) = %q, want %q", c.prefix, got, c.want)
+		}
+	}
+}
+
+func TestAddWaitParam(t *testing.T) {
+	cases := []struct {
+		in, want string
+	}{
+		{"http://example.com/routes", "http://example.com/routes?wait=60s"},
+		{"http://example.com/routes?token=abc", "http://example.com/routes?token=abc&wait=60s"},
+	}
+	for _, c := range cases {
+		got, err := addWaitParam(c.in, "60s")
+		if err != nil {
+			t.Fatalf("addWaitParam(%q): %s", c.in, err)
+		}
+		if got != c.want {
+			t.Errorf("addWaitParam(%q) = %q, want %q", c.in, got, c.want)
+		}
+	}
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// connInfo is a point-in-time, read-only snapshot of one active Conn,
+// for the admin /connections endpoint. Conn.proxy publishes a fresh
+// connInfo (rather than mutating one in place) whenever it learns
+// more about a connection, so readers never see a half-updated value.
+type connInfo struct {
+	RemoteAddr string    `json:"remote_addr"`
+	Hostname   string    `json:"hostname,omitempty"`
+	Backend    string    `json:"backend,omitempty"`
+	Since      time.Time `json:"since"`
+}
+
+var activeConns sync.Map // *Conn -> *connInfo
+
+func registerConn(c *Conn) {
+	activeConns.Store(c, &connInfo{RemoteAddr: c.RemoteAddr().String(), Since: c.startTime})
+	activeConnectionsGauge.Inc()
+}
+
+// updateConnInfo republishes c's entry in the active-connections
+// registry once its hostname and backend are known.
+func updateConnInfo(c *Conn) {
+	activeConns.Store(c, &connInfo{
+		RemoteAddr: c.RemoteAddr().String(),
+		Hostname:   c.hostname,
+		Backend:    c.backend,
+		Since:      c.startTime,
+	})
+}
+
+func unregisterConn(c *Conn) {
+	activeConns.Delete(c)
+	activeConnectionsGauge.Dec()
+}
+
+// snapshotConns returns the currently active connections, for the
+// /connections admin endpoint.
+func snapshotConns() []connInfo {
+	var out []connInfo
+	activeConns.Range(func(_, v interface{}) bool {
+		out = append(out, *v.(*connInfo))
+		return true
+	})
+	return out
+}
+
+// accessLogEntry is one line of the JSON access log emitted when a
+// connection closes, suitable for shipping to ELK/Loki.
+type accessLogEntry struct {
+	RemoteAddr string `json:"remote_addr"`
+	Hostname   string `json:"hostname,omitempty"`
+	Backend    string `json:"backend,omitempty"`
+	BytesIn    int64  `json:"bytes_in"`
+	BytesOut   int64  `json:"bytes_out"`
+	DurationMS int64  `json:"duration_ms"`
+}
+
+// accessLog writes one JSON object per line, with no extra prefix or
+// timestamp (the entry carries its own), so it can be shipped
+// straight to a log pipeline without further parsing.
+var accessLog = log.New(os.Stdout, "", 0)
+
+func logAccess(c *Conn) {
+	entry := accessLogEntry{
+		RemoteAddr: c.RemoteAddr().String(),
+		Hostname:   c.hostname,
+		Backend:    c.backend,
+		BytesIn:    c.bytesIn,
+		BytesOut:   c.bytesOut,
+		DurationMS: time.Since(c.startTime).Milliseconds(),
+	}
+	b, err := json.Marshal(entry)
+	if err != nil {
+		log.Printf("marshaling access log entry: %s", err)
+		return
+	}
+	accessLog.Println(string(b))
+}
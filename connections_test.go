@@ -0,0 +1,123 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"log"
+	"net"
+	"os"
+	"testing"
+	"time"
+)
+
+// newTestConn returns a Conn backed by a real loopback TCP connection,
+// so RemoteAddr() (and anything else that touches the embedded
+// net.TCPConn) works as it would on a live connection.
+func newTestConn(t *testing.T) *Conn {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %s", err)
+	}
+	defer l.Close()
+
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		c, err := l.Accept()
+		if err == nil {
+			accepted <- c
+		}
+	}()
+
+	c, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatalf("dial: %s", err)
+	}
+	t.Cleanup(func() {
+		c.Close()
+		(<-accepted).Close()
+	})
+
+	return &Conn{TCPConn: c.(*net.TCPConn), startTime: time.Now()}
+}
+
+func TestRegisterUpdateUnregisterConn(t *testing.T) {
+	before := activeConnectionsGauge.Value()
+
+	c := newTestConn(t)
+	registerConn(c)
+	if got := activeConnectionsGauge.Value(); got != before+1 {
+		t.Fatalf("gauge after register = %d, want %d", got, before+1)
+	}
+
+	found := false
+	for _, info := range snapshotConns() {
+		if info.RemoteAddr == c.RemoteAddr().String() {
+			found = true
+		}
+	}
+	if !found {
+		t.Error("snapshotConns does not include the registered connection")
+	}
+
+	c.hostname = "example.com"
+	c.backend = "10.0.0.1:443"
+	updateConnInfo(c)
+	found = false
+	for _, info := range snapshotConns() {
+		if info.RemoteAddr == c.RemoteAddr().String() {
+			found = true
+			if info.Hostname != "example.com" || info.Backend != "10.0.0.1:443" {
+				t.Errorf("connInfo = %+v, want hostname/backend populated", info)
+			}
+		}
+	}
+	if !found {
+		t.Error("snapshotConns does not include the connection after updateConnInfo")
+	}
+
+	unregisterConn(c)
+	if got := activeConnectionsGauge.Value(); got != before {
+		t.Errorf("gauge after unregister = %d, want %d", got, before)
+	}
+	for _, info := range snapshotConns() {
+		if info.RemoteAddr == c.RemoteAddr().String() {
+			t.Error("snapshotConns still includes the connection after unregisterConn")
+		}
+	}
+}
+
+func TestLogAccess(t *testing.T) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatalf("os.Pipe: %s", err)
+	}
+	old := accessLog
+	accessLog = log.New(w, "", 0)
+	defer func() { accessLog = old }()
+
+	c := newTestConn(t)
+	c.hostname = "example.com"
+	c.backend = "10.0.0.1:443"
+	c.bytesIn = 100
+	c.bytesOut = 200
+
+	logAccess(c)
+	w.Close()
+
+	data, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatalf("reading captured log: %s", err)
+	}
+
+	var entry accessLogEntry
+	if err := json.Unmarshal(data, &entry); err != nil {
+		t.Fatalf("unmarshaling access log line %q: %s", data, err)
+	}
+	if entry.Hostname != "example.com" || entry.Backend != "10.0.0.1:443" {
+		t.Errorf("entry = %+v, want hostname/backend populated", entry)
+	}
+	if entry.BytesIn != 100 || entry.BytesOut != 200 {
+		t.Errorf("entry = %+v, want bytes_in=100 bytes_out=200", entry)
+	}
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"time"
+)
+
+// dialBackend tries route's backends, healthiest-first as ranked by
+// its Picker, until one accepts a connection. It only gives up once
+// every healthy candidate has failed to dial.
+func dialBackend(route *Route, hostname string) (*net.TCPConn, error) {
+	candidates := route.healthyBackends()
+	if len(candidates) == 0 {
+		// Nothing is known to be healthy; fall back to trying
+		// everything rather than failing a client outright.
+		candidates = append(candidates, route.backends...)
+	}
+
+	var lastErr error
+	for len(candidates) > 0 {
+		b := route.picker.Pick(hostname, candidates)
+		if b == nil {
+			break
+		}
+
+		start := time.Now()
+		conn, err := net.DialTimeout("tcp", b.Addr, 10*time.Second)
+		if err == nil {
+			backendDialSeconds.Observe(time.Since(start).Seconds())
+			return conn.(*net.TCPConn), nil
+		}
+		lastErr = err
+
+		candidates = removeBackend(candidates, b)
+	}
+
+	if lastErr == nil {
+		lastErr = fmt.Errorf("no backends configured")
+	}
+	return nil, lastErr
+}
+
+func removeBackend(candidates []*Backend, b *Backend) []*Backend {
+	out := candidates[:0]
+	for _, c := range candidates {
+		if c != b {
+			out = append(out, c)
+		}
+	}
+	return out
+}
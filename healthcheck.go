@@ -0,0 +1,94 @@
+package main
+
+import (
+	"crypto/tls"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Backend is one candidate destination for a Route: an address plus
+// its relative weight and live health status.
+type Backend struct {
+	Addr   string
+	Weight int
+
+	// healthy is 1 (up) or 0 (down), set by the background health
+	// checker. It starts at 1 so routing works immediately, before
+	// the first check has had a chance to run.
+	healthy int32
+}
+
+func newBackend(addr string, weight int) *Backend {
+	return &Backend{Addr: addr, Weight: weight, healthy: 1}
+}
+
+// Healthy reports whether the most recent health check considered
+// this backend reachable.
+func (b *Backend) Healthy() bool { return atomic.LoadInt32(&b.healthy) == 1 }
+
+func (b *Backend) setHealthy(v bool) {
+	n := int32(0)
+	if v {
+		n = 1
+	}
+	atomic.StoreInt32(&b.healthy, n)
+}
+
+// healthCheckOpts configures the background health checker started by
+// Router.StartHealthChecks.
+type healthCheckOpts struct {
+	interval time.Duration
+	timeout  time.Duration
+	tlsProbe bool
+}
+
+// probeAll concurrently probes every backend in backends and updates
+// its health state in place.
+func probeAll(backends []*Backend, opts healthCheckOpts) {
+	var wg sync.WaitGroup
+	for _, b := range backends {
+		wg.Add(1)
+		go func(b *Backend) {
+			defer wg.Done()
+			b.setHealthy(probeBackend(b.Addr, opts))
+		}(b)
+	}
+	wg.Wait()
+}
+
+// allBackends returns every Backend referenced by the current routing
+// table, across all routes.
+func (c *Config) allBackends() []*Backend {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var backends []*Backend
+	for _, r := range c.routes {
+		backends = append(backends, r.backends...)
+	}
+	return backends
+}
+
+// probeBackend reports whether addr looks reachable: a plain TCP dial
+// by default, or a full TLS handshake (abandoned immediately after
+// success) when opts.tlsProbe is set, to also catch a backend whose
+// listener is up but whose TLS stack is wedged.
+func probeBackend(addr string, opts healthCheckOpts) bool {
+	if opts.tlsProbe {
+		conn, err := tls.DialWithDialer(&net.Dialer{Timeout: opts.timeout}, "tcp", addr, &tls.Config{InsecureSkipVerify: true})
+		if err != nil {
+			return false
+		}
+		conn.Close()
+		return true
+	}
+
+	conn, err := net.DialTimeout("tcp", addr, opts.timeout)
+	if err != nil {
+		return false
+	}
+	conn.Close()
+	return true
+}
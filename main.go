@@ -1,7 +1,9 @@
 package main
 
 import (
+	"bufio"
 	"bytes"
+	"expvar"
 	"flag"
 	"fmt"
 	"io"
@@ -13,15 +15,44 @@ import (
 
 var cfgFile = flag.String("conf", "", "configuration file")
 var listen = flag.String("listen", ":443", "listening port")
-
-var config Config
+var trustedProxyCIDRs = flag.String("trusted-proxy-cidrs", "", "comma-separated CIDRs allowed to present an inbound PROXY protocol header (e.g. when tlsrouter is chained behind another load balancer)")
+var healthCheckInterval = flag.Duration("health-check-interval", 5*time.Second, "how often to probe backends for liveness")
+var healthCheckTimeout = flag.Duration("health-check-timeout", 2*time.Second, "timeout for a single backend health probe")
+var healthCheckTLS = flag.Bool("health-check-tls", false, "probe backends with a full TLS handshake instead of a plain TCP dial")
+var splicePipeSize = flag.Int("splice-pipe-size", 0, "size in bytes of the intermediate pipe used for the splice(2) fast path (Linux only; 0 leaves the kernel default)")
+
+var router *Router
+var trustedProxySources *proxyACL
+
+// Byte counters for the two directions of the proxy loop. They used
+// to be hidden inside io.Copy; now that each direction calls pump
+// directly, we can surface them.
+var (
+	bytesClientToBackend = expvar.NewInt("tlsrouter_bytes_client_to_backend")
+	bytesBackendToClient = expvar.NewInt("tlsrouter_bytes_backend_to_client")
+)
 
 func main() {
 	flag.Parse()
 
-	if err := config.ReadFile(*cfgFile); err != nil {
+	initial := &Config{}
+	if err := initial.ReadFile(*cfgFile); err != nil {
 		log.Fatalf("Failed to read config %q: %s", *cfgFile, err)
 	}
+	router = newRouter(initial)
+	startConfigSource(router)
+	router.StartHealthChecks(healthCheckOpts{
+		interval: *healthCheckInterval,
+		timeout:  *healthCheckTimeout,
+		tlsProbe: *healthCheckTLS,
+	})
+	startAdmin()
+
+	var err error
+	trustedProxySources, err = parseProxyACL(*trustedProxyCIDRs)
+	if err != nil {
+		log.Fatalf("Failed to parse -trusted-proxy-cidrs: %s", err)
+	}
 
 	l, err := net.Listen("tcp", *listen)
 	if err != nil {
@@ -34,7 +65,9 @@ func main() {
 			log.Fatalf("Error while accepting: %s", err)
 		}
 
-		conn := &Conn{TCPConn: c.(*net.TCPConn)}
+		conn := &Conn{TCPConn: c.(*net.TCPConn), startTime: time.Now()}
+		connectionsAccepted.Inc()
+		registerConn(conn)
 		go conn.proxy()
 	}
 }
@@ -44,8 +77,27 @@ type Conn struct {
 
 	tlsMinor    int
 	hostname    string
+	alpnProtos  []string
 	backend     string
 	backendConn *net.TCPConn
+
+	startTime         time.Time
+	bytesIn, bytesOut int64
+
+	// realRemoteAddr overrides RemoteAddr() when the true client
+	// address was recovered from an inbound PROXY protocol header
+	// rather than the TCP connection itself.
+	realRemoteAddr net.Addr
+}
+
+// RemoteAddr returns the address of the real client, which may differ
+// from the TCP peer address if it arrived via a trusted PROXY
+// protocol header.
+func (c *Conn) RemoteAddr() net.Addr {
+	if c.realRemoteAddr != nil {
+		return c.realRemoteAddr
+	}
+	return c.TCPConn.RemoteAddr()
 }
 
 func (c *Conn) log(msg string, args ...interface{}) {
@@ -66,32 +118,72 @@ func (c *Conn) sniFailed(msg string, args ...interface{})     { c.abort(112, msg
 
 func (c *Conn) proxy() {
 	defer c.Close()
+	defer unregisterConn(c)
+	defer logAccess(c)
+
+	br := bufio.NewReader(c.TCPConn)
+	if tcpAddr, ok := c.TCPConn.RemoteAddr().(*net.TCPAddr); ok && trustedProxySources.Trusted(tcpAddr.IP) {
+		src, _, err := readProxyHeader(br)
+		if err != nil {
+			c.internalError("reading inbound PROXY header: %s", err)
+			return
+		}
+		if src != nil {
+			c.realRemoteAddr = src
+		}
+	}
 
 	var (
 		err          error
 		handshakeBuf bytes.Buffer
 	)
-	c.hostname, c.tlsMinor, err = extractSNI(io.TeeReader(c, &handshakeBuf))
+	c.hostname, c.alpnProtos, c.tlsMinor, err = extractClientHello(io.TeeReader(br, &handshakeBuf))
 	if err != nil {
+		sniExtractionFailed.Inc()
 		c.internalError("Extracting SNI: %s", err)
 		return
 	}
+	// br may have buffered bytes past the handshake record it read;
+	// the rest of proxy() reads directly off the raw TCPConn, so fold
+	// any read-ahead into the buffer we're about to replay.
+	if n := br.Buffered(); n > 0 {
+		if _, err := io.CopyN(&handshakeBuf, br, int64(n)); err != nil {
+			c.internalError("draining buffered bytes: %s", err)
+			return
+		}
+	}
 
-	c.backend = config.Match(c.hostname)
-	if c.backend == "" {
+	route := router.Match(c.hostname, c.alpnProtos)
+	if route == nil {
 		c.sniFailed("no backend found for %q", c.hostname)
 		return
 	}
 
-	c.log("routing %q to %q", c.hostname, c.backend)
-	backend, err := net.DialTimeout("tcp", c.backend, 10*time.Second)
+	backendConn, err := dialBackend(route, c.hostname)
 	if err != nil {
-		c.internalError("failed to dial backend %q for %q: %s", c.backend, c.hostname, err)
+		c.sniFailed("no healthy backend available for %q: %s", c.hostname, err)
 		return
 	}
-	defer backend.Close()
+	c.backend = backendConn.RemoteAddr().String()
+	defer backendConn.Close()
+
+	c.log("routing %q to %q", c.hostname, c.backend)
+	c.backendConn = backendConn
+	updateConnInfo(c)
 
-	c.backendConn = backend.(*net.TCPConn)
+	if route.sendProxy != proxyProtoNone {
+		clientAddr, _ := c.RemoteAddr().(*net.TCPAddr)
+		listenAddr, _ := c.TCPConn.LocalAddr().(*net.TCPAddr)
+		header, err := writeProxyHeader(route.sendProxy, clientAddr, listenAddr, c.hostname)
+		if err != nil {
+			c.internalError("building PROXY header for %q: %s", c.backend, err)
+			return
+		}
+		if _, err := c.backendConn.Write(header); err != nil {
+			c.internalError("sending PROXY header to %q: %s", c.backend, err)
+			return
+		}
+	}
 
 	// Replay the piece of the handshake we had to read to do the
 	// routing, then blindly proxy any other bytes.
@@ -100,19 +192,26 @@ func (c *Conn) proxy() {
 		return
 	}
 
+	routeCounters := countersForRoute(route.pattern)
 	var wg sync.WaitGroup
 	wg.Add(2)
-	go proxy(&wg, c.TCPConn, c.backendConn)
-	go proxy(&wg, c.backendConn, c.TCPConn)
+	go proxy(&wg, c.TCPConn, c.backendConn, byteSink{global: bytesBackendToClient, route: &routeCounters.out, conn: &c.bytesOut})
+	go proxy(&wg, c.backendConn, c.TCPConn, byteSink{global: bytesClientToBackend, route: &routeCounters.in, conn: &c.bytesIn})
 	wg.Wait()
 }
 
-func proxy(wg *sync.WaitGroup, a, b net.Conn) {
+// proxy copies from src to dst until src is exhausted or an error
+// occurs, adding the number of bytes moved to counter. It uses pump
+// rather than a bare io.Copy so that platforms with a splice(2) fast
+// path (see splice_linux.go) get it, and so the byte count - otherwise
+// hidden inside the copy - is available to callers.
+func proxy(wg *sync.WaitGroup, dst, src *net.TCPConn, counter byteSink) {
 	defer wg.Done()
-	atcp, btcp := a.(*net.TCPConn), b.(*net.TCPConn)
-	if _, err := io.Copy(atcp, btcp); err != nil {
-		log.Printf("%s<>%s -> %s<>%s: %s", atcp.RemoteAddr(), atcp.LocalAddr(), btcp.LocalAddr(), btcp.RemoteAddr(), err)
+	n, err := pump(dst, src)
+	counter.Add(n)
+	if err != nil {
+		log.Printf("%s<>%s -> %s<>%s: %s", dst.RemoteAddr(), dst.LocalAddr(), src.LocalAddr(), src.RemoteAddr(), err)
 	}
-	btcp.CloseWrite()
-	atcp.CloseRead()
+	src.CloseWrite()
+	dst.CloseRead()
 }
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"expvar"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// The metrics below are deliberately hand-rolled rather than pulled
+// in from a Prometheus client library, to keep tlsrouter a
+// dependency-free binary; counter/gauge() render in the standard
+// Prometheus text exposition format, so any Prometheus-compatible
+// scraper can still consume /metrics directly.
+
+// Counter is a monotonically increasing value.
+type Counter struct{ v int64 }
+
+func (c *Counter) Inc()         { atomic.AddInt64(&c.v, 1) }
+func (c *Counter) Add(n int64)  { atomic.AddInt64(&c.v, n) }
+func (c *Counter) Value() int64 { return atomic.LoadInt64(&c.v) }
+
+// Gauge is a value that can go up or down.
+type Gauge struct{ v int64 }
+
+func (g *Gauge) Inc()         { atomic.AddInt64(&g.v, 1) }
+func (g *Gauge) Dec()         { atomic.AddInt64(&g.v, -1) }
+func (g *Gauge) Value() int64 { return atomic.LoadInt64(&g.v) }
+
+// Histogram is a fixed-bucket latency histogram, sufficient for the
+// rough dial-latency distribution tlsrouter wants to expose without
+// needing a real metrics library.
+type Histogram struct {
+	mu      sync.Mutex
+	buckets []float64 // upper bounds, ascending, not including +Inf
+	counts  []int64   // counts[i] = observations <= buckets[i]
+	sum     float64
+	total   int64
+}
+
+func newHistogram(buckets []float64) *Histogram {
+	return &Histogram{buckets: buckets, counts: make([]int64, len(buckets))}
+}
+
+func (h *Histogram) Observe(v float64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.sum += v
+	h.total++
+	for i, bound := range h.buckets {
+		if v <= bound {
+			h.counts[i]++
+		}
+	}
+}
+
+func (h *Histogram) snapshot() (buckets []float64, counts []int64, sum float64, total int64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return append([]float64(nil), h.buckets...), append([]int64(nil), h.counts...), h.sum, h.total
+}
+
+// Global metrics, wired into Conn.proxy, dialBackend, and main's
+// accept loop.
+var (
+	connectionsAccepted    = &Counter{}
+	sniExtractionFailed    = &Counter{}
+	activeConnectionsGauge = &Gauge{}
+	backendDialSeconds     = newHistogram([]float64{0.001, 0.005, 0.01, 0.05, 0.1, 0.5, 1, 5, 10})
+)
+
+// routeByteCounters tracks bytes proxied per route pattern, surviving
+// config reloads (which replace the *Route values metrics are
+// attributed to, but not their pattern strings).
+var routeByteCounters sync.Map // pattern string -> *routeBytes
+
+type routeBytes struct {
+	in, out Counter
+}
+
+func countersForRoute(pattern string) *routeBytes {
+	v, _ := routeByteCounters.LoadOrStore(pattern, &routeBytes{})
+	return v.(*routeBytes)
+}
+
+// byteSink fans a byte count out to every place tlsrouter tracks it:
+// the process-wide expvar counter (added in splice_linux.go/main.go),
+// the per-route counter above, and a per-connection counter for the
+// access log and /connections endpoint. Previously these lived hidden
+// inside io.Copy; proxy() now reports through a byteSink instead.
+type byteSink struct {
+	global *expvar.Int
+	route  *Counter
+	conn   *int64
+}
+
+func (s byteSink) Add(n int64) {
+	s.global.Add(n)
+	s.route.Add(n)
+	atomic.AddInt64(s.conn, n)
+}
+
+// writeMetrics renders all metrics in Prometheus text exposition
+// format.
+func writeMetrics(w *strings.Builder) {
+	fmt.Fprintf(w, "# HELP tlsrouter_connections_accepted_total Connections accepted on the listening socket.\n")
+	fmt.Fprintf(w, "# TYPE tlsrouter_connections_accepted_total counter\n")
+	fmt.Fprintf(w, "tlsrouter_connections_accepted_total %d\n", connectionsAccepted.Value())
+
+	fmt.Fprintf(w, "# HELP tlsrouter_sni_extraction_failed_total ClientHellos tlsrouter failed to extract a hostname from.\n")
+	fmt.Fprintf(w, "# TYPE tlsrouter_sni_extraction_failed_total counter\n")
+	fmt.Fprintf(w, "tlsrouter_sni_extraction_failed_total %d\n", sniExtractionFailed.Value())
+
+	fmt.Fprintf(w, "# HELP tlsrouter_active_connections Connections currently being proxied.\n")
+	fmt.Fprintf(w, "# TYPE tlsrouter_active_connections gauge\n")
+	fmt.Fprintf(w, "tlsrouter_active_connections %d\n", activeConnectionsGauge.Value())
+
+	fmt.Fprintf(w, "# HELP tlsrouter_backend_dial_seconds Time to establish a backend connection.\n")
+	fmt.Fprintf(w, "# TYPE tlsrouter_backend_dial_seconds histogram\n")
+	buckets, counts, sum, total := backendDialSeconds.snapshot()
+	for i, bound := range buckets {
+		fmt.Fprintf(w, "tlsrouter_backend_dial_seconds_bucket{le=\"%g\"} %d\n", bound, counts[i])
+	}
+	fmt.Fprintf(w, "tlsrouter_backend_dial_seconds_bucket{le=\"+Inf\"} %d\n", total)
+	fmt.Fprintf(w, "tlsrouter_backend_dial_seconds_sum %g\n", sum)
+	fmt.Fprintf(w, "tlsrouter_backend_dial_seconds_count %d\n", total)
+
+	fmt.Fprintf(w, "# HELP tlsrouter_route_bytes_total Bytes proxied per route, by direction.\n")
+	fmt.Fprintf(w, "# TYPE tlsrouter_route_bytes_total counter\n")
+	var patterns []string
+	routeByteCounters.Range(func(k, _ interface{}) bool {
+		patterns = append(patterns, k.(string))
+		return true
+	})
+	sort.Strings(patterns)
+	for _, pattern := range patterns {
+		rb := countersForRoute(pattern)
+		fmt.Fprintf(w, "tlsrouter_route_bytes_total{route=%q,direction=\"in\"} %d\n", pattern, rb.in.Value())
+		fmt.Fprintf(w, "tlsrouter_route_bytes_total{route=%q,direction=\"out\"} %d\n", pattern, rb.out.Value())
+	}
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"expvar"
+	"strings"
+	"testing"
+)
+
+func TestHistogramObserve(t *testing.T) {
+	h := newHistogram([]float64{0.1, 1, 10})
+	h.Observe(0.05)
+	h.Observe(0.5)
+	h.Observe(5)
+	h.Observe(50)
+
+	buckets, counts, sum, total := h.snapshot()
+	if len(buckets) != 3 {
+		t.Fatalf("buckets = %v, want 3 entries", buckets)
+	}
+	wantCounts := []int64{1, 2, 3} // <=0.1: 1; <=1: 2 (0.05,0.5); <=10: 3 (+5)
+	for i, want := range wantCounts {
+		if counts[i] != want {
+			t.Errorf("counts[%d] = %d, want %d", i, counts[i], want)
+		}
+	}
+	if total != 4 {
+		t.Errorf("total = %d, want 4", total)
+	}
+	if sum != 0.05+0.5+5+50 {
+		t.Errorf("sum = %g, want %g", sum, 0.05+0.5+5+50)
+	}
+}
+
+func TestByteSinkAdd(t *testing.T) {
+	global := expvar.NewInt("test_bytesink_global_" + t.Name())
+	route := &Counter{}
+	var conn int64
+
+	s := byteSink{global: global, route: route, conn: &conn}
+	s.Add(42)
+	s.Add(8)
+
+	if got := global.Value(); got != 50 {
+		t.Errorf("global = %d, want 50", got)
+	}
+	if got := route.Value(); got != 50 {
+		t.Errorf("route = %d, want 50", got)
+	}
+	if conn != 50 {
+		t.Errorf("conn = %d, want 50", conn)
+	}
+}
+
+func TestCountersForRouteIsStable(t *testing.T) {
+	a := countersForRoute("test-pattern-" + t.Name())
+	b := countersForRoute("test-pattern-" + t.Name())
+	if a != b {
+		t.Error("countersForRoute returned different counters for the same pattern")
+	}
+}
+
+func TestWriteMetricsIncludesRouteBytes(t *testing.T) {
+	rb := countersForRoute("metrics-test-route-" + t.Name())
+	rb.in.Add(7)
+	rb.out.Add(3)
+
+	var b strings.Builder
+	writeMetrics(&b)
+	out := b.String()
+
+	if !strings.Contains(out, `route="metrics-test-route-`+t.Name()+`",direction="in"} 7`) {
+		t.Errorf("writeMetrics output missing in-bytes line, got:\n%s", out)
+	}
+	if !strings.Contains(out, `route="metrics-test-route-`+t.Name()+`",direction="out"} 3`) {
+		t.Errorf("writeMetrics output missing out-bytes line, got:\n%s", out)
+	}
+	if !strings.Contains(out, "tlsrouter_active_connections") {
+		t.Errorf("writeMetrics output missing active connections gauge, got:\n%s", out)
+	}
+}
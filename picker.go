@@ -0,0 +1,87 @@
+package main
+
+import (
+	"hash/fnv"
+	"math/rand"
+	"sync/atomic"
+)
+
+// Picker selects the next backend to try for a connection to
+// hostname, given the set of currently healthy candidates for its
+// route. Conn.proxy calls Pick repeatedly, removing each candidate
+// that fails to dial, so a Picker only needs to rank candidates - it
+// does not need to track which ones have already failed.
+//
+// Implementations that want sticky routing (e.g. consistent hashing
+// on SNI) can use hostname to make the same backend win across calls,
+// as long as the candidate set doesn't change.
+type Picker interface {
+	Pick(hostname string, candidates []*Backend) *Backend
+}
+
+// weightedRandomPicker chooses a candidate at random, weighted by
+// Backend.Weight. It's the default Picker for routes that don't
+// request something else.
+type weightedRandomPicker struct{}
+
+func (weightedRandomPicker) Pick(hostname string, candidates []*Backend) *Backend {
+	if len(candidates) == 0 {
+		return nil
+	}
+	total := 0
+	for _, b := range candidates {
+		total += b.Weight
+	}
+	if total <= 0 {
+		return candidates[rand.Intn(len(candidates))]
+	}
+	n := rand.Intn(total)
+	for _, b := range candidates {
+		if n < b.Weight {
+			return b
+		}
+		n -= b.Weight
+	}
+	return candidates[len(candidates)-1]
+}
+
+// roundRobinPicker cycles through candidates in order, ignoring
+// weight. It keeps its own counter, so a single roundRobinPicker
+// should be shared by all connections for a route (as Config does).
+// Pick is called concurrently from every Conn.proxy goroutine routed
+// to that route, so next is only ever touched through sync/atomic.
+type roundRobinPicker struct {
+	next uint32
+}
+
+func (p *roundRobinPicker) Pick(hostname string, candidates []*Backend) *Backend {
+	if len(candidates) == 0 {
+		return nil
+	}
+	i := atomic.AddUint32(&p.next, 1) % uint32(len(candidates))
+	return candidates[i]
+}
+
+// sniStickyPicker uses rendezvous (highest random weight) hashing on
+// hostname so that, as long as the candidate set is stable, the same
+// hostname always maps to the same backend. This gives sticky routing
+// without a shared session table, at the cost of some reshuffling
+// when backends come up or down.
+type sniStickyPicker struct{}
+
+func (sniStickyPicker) Pick(hostname string, candidates []*Backend) *Backend {
+	if len(candidates) == 0 {
+		return nil
+	}
+	var best *Backend
+	var bestScore uint32
+	for _, b := range candidates {
+		h := fnv.New32a()
+		h.Write([]byte(hostname))
+		h.Write([]byte(b.Addr))
+		if score := h.Sum32(); best == nil || score > bestScore {
+			best, bestScore = b, score
+		}
+	}
+	return best
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"sync"
+	"testing"
+)
+
+func TestRoundRobinPickerCycles(t *testing.T) {
+	candidates := []*Backend{newBackend("a:1", 1), newBackend("b:1", 1), newBackend("c:1", 1)}
+	p := &roundRobinPicker{}
+
+	var got []string
+	for i := 0; i < 6; i++ {
+		got = append(got, p.Pick("host", candidates).Addr)
+	}
+	want := []string{"b:1", "c:1", "a:1", "b:1", "c:1", "a:1"}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("pick %d = %s, want %s (full sequence %v)", i, got[i], want[i], got)
+		}
+	}
+}
+
+func TestRoundRobinPickerEmpty(t *testing.T) {
+	p := &roundRobinPicker{}
+	if b := p.Pick("host", nil); b != nil {
+		t.Errorf("Pick with no candidates = %v, want nil", b)
+	}
+}
+
+// TestRoundRobinPickerConcurrent exercises Pick the way dialBackend
+// does: many goroutines sharing one picker, as a route does in
+// practice. Run with -race to catch the data race this guards
+// against.
+func TestRoundRobinPickerConcurrent(t *testing.T) {
+	candidates := []*Backend{newBackend("a:1", 1), newBackend("b:1", 1)}
+	p := &roundRobinPicker{}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				p.Pick("host", candidates)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func TestSNIStickyPickerIsSticky(t *testing.T) {
+	candidates := []*Backend{newBackend("a:1", 1), newBackend("b:1", 1), newBackend("c:1", 1)}
+	p := sniStickyPicker{}
+
+	first := p.Pick("www.example.com", candidates)
+	for i := 0; i < 10; i++ {
+		if got := p.Pick("www.example.com", candidates); got != first {
+			t.Fatalf("Pick(%q) = %s on call %d, want consistently %s", "www.example.com", got.Addr, i, first.Addr)
+		}
+	}
+}
+
+func TestWeightedRandomPickerRespectsZeroWeight(t *testing.T) {
+	candidates := []*Backend{newBackend("a:1", 0), newBackend("b:1", 0)}
+	p := weightedRandomPicker{}
+	if b := p.Pick("host", candidates); b == nil {
+		t.Error("Pick with all-zero weights = nil, want a fallback candidate")
+	}
+}
@@ -0,0 +1,239 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// proxyProtoVersion selects whether (and how) tlsrouter prepends a
+// PROXY protocol header before replaying a connection's bytes to its
+// backend. It mirrors HAProxy's "send-proxy" / "send-proxy-v2" route
+// options.
+type proxyProtoVersion int
+
+const (
+	proxyProtoNone proxyProtoVersion = iota
+	proxyProtoV1
+	proxyProtoV2
+)
+
+func parseProxyProtoVersion(s string) (proxyProtoVersion, error) {
+	switch s {
+	case "send-proxy":
+		return proxyProtoV1, nil
+	case "send-proxy-v2":
+		return proxyProtoV2, nil
+	default:
+		return proxyProtoNone, fmt.Errorf("unknown proxy option %q", s)
+	}
+}
+
+var proxyProtoV2Signature = []byte{0x0D, 0x0A, 0x0D, 0x0A, 0x00, 0x0D, 0x0A, 0x51, 0x55, 0x49, 0x54, 0x0A}
+
+// pp2TypeAuthority is the TLV type PROXY protocol v2 uses to carry a
+// hostname (here: the TLS SNI we already extracted, so the backend
+// doesn't need to re-derive it).
+const pp2TypeAuthority = 0x02
+
+// writeProxyHeader writes a PROXY protocol header describing a
+// connection from src to dst to w, in the given version. sni, if
+// non-empty, is attached as a PP2_TYPE_AUTHORITY TLV on v2; v1 has no
+// equivalent and simply omits it.
+func writeProxyHeader(version proxyProtoVersion, src, dst *net.TCPAddr, sni string) ([]byte, error) {
+	switch version {
+	case proxyProtoV1:
+		return writeProxyHeaderV1(src, dst)
+	case proxyProtoV2:
+		return writeProxyHeaderV2(src, dst, sni)
+	default:
+		return nil, nil
+	}
+}
+
+func writeProxyHeaderV1(src, dst *net.TCPAddr) ([]byte, error) {
+	family := "TCP4"
+	if src.IP.To4() == nil {
+		family = "TCP6"
+	}
+	line := fmt.Sprintf("PROXY %s %s %s %d %d\r\n", family, src.IP.String(), dst.IP.String(), src.Port, dst.Port)
+	return []byte(line), nil
+}
+
+func writeProxyHeaderV2(src, dst *net.TCPAddr, sni string) ([]byte, error) {
+	var buf bytes.Buffer
+	buf.Write(proxyProtoV2Signature)
+	buf.WriteByte(0x21) // version 2, command PROXY
+
+	srcIP4, dstIP4 := src.IP.To4(), dst.IP.To4()
+	var addrBytes []byte
+	if srcIP4 != nil && dstIP4 != nil {
+		buf.WriteByte(0x11) // AF_INET, STREAM
+		addrBytes = make([]byte, 12)
+		copy(addrBytes[0:4], srcIP4)
+		copy(addrBytes[4:8], dstIP4)
+		binary.BigEndian.PutUint16(addrBytes[8:10], uint16(src.Port))
+		binary.BigEndian.PutUint16(addrBytes[10:12], uint16(dst.Port))
+	} else {
+		buf.WriteByte(0x21) // AF_INET6, STREAM
+		addrBytes = make([]byte, 36)
+		copy(addrBytes[0:16], src.IP.To16())
+		copy(addrBytes[16:32], dst.IP.To16())
+		binary.BigEndian.PutUint16(addrBytes[32:34], uint16(src.Port))
+		binary.BigEndian.PutUint16(addrBytes[34:36], uint16(dst.Port))
+	}
+
+	var tlv []byte
+	if sni != "" {
+		tlv = make([]byte, 3+len(sni))
+		tlv[0] = pp2TypeAuthority
+		binary.BigEndian.PutUint16(tlv[1:3], uint16(len(sni)))
+		copy(tlv[3:], sni)
+	}
+
+	length := len(addrBytes) + len(tlv)
+	lenBytes := make([]byte, 2)
+	binary.BigEndian.PutUint16(lenBytes, uint16(length))
+	buf.Write(lenBytes)
+	buf.Write(addrBytes)
+	buf.Write(tlv)
+
+	return buf.Bytes(), nil
+}
+
+// proxyACL is a list of CIDR blocks trusted to present an inbound
+// PROXY protocol header on the listening socket. Connections from any
+// other source have their header, if any, passed straight through to
+// the SNI parser (and will almost certainly fail it).
+type proxyACL struct {
+	nets []*net.IPNet
+}
+
+// parseProxyACL parses a comma-separated list of CIDRs, e.g.
+// "10.0.0.0/8,192.168.1.1/32".
+func parseProxyACL(s string) (*proxyACL, error) {
+	if s == "" {
+		return &proxyACL{}, nil
+	}
+	acl := &proxyACL{}
+	for _, field := range strings.Split(s, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		_, ipnet, err := net.ParseCIDR(field)
+		if err != nil {
+			return nil, fmt.Errorf("parsing trusted CIDR %q: %s", field, err)
+		}
+		acl.nets = append(acl.nets, ipnet)
+	}
+	return acl, nil
+}
+
+// Trusted reports whether ip is allowed to present a PROXY protocol
+// header.
+func (a *proxyACL) Trusted(ip net.IP) bool {
+	if a == nil {
+		return false
+	}
+	for _, n := range a.nets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// readProxyHeader reads and parses a PROXY protocol header (v1 or v2)
+// from br, returning the real client and destination addresses it
+// describes. br must not have had any bytes consumed from the
+// connection yet.
+func readProxyHeader(br *bufio.Reader) (src, dst *net.TCPAddr, err error) {
+	peek, err := br.Peek(len(proxyProtoV2Signature))
+	if err == nil && bytes.Equal(peek, proxyProtoV2Signature) {
+		return readProxyHeaderV2(br)
+	}
+	return readProxyHeaderV1(br)
+}
+
+func readProxyHeaderV1(br *bufio.Reader) (src, dst *net.TCPAddr, err error) {
+	line, err := br.ReadString('\n')
+	if err != nil {
+		return nil, nil, fmt.Errorf("reading v1 header: %s", err)
+	}
+	line = strings.TrimRight(line, "\r\n")
+	fields := strings.Fields(line)
+	if len(fields) == 2 && fields[0] == "PROXY" && fields[1] == "UNKNOWN" {
+		// The sender has no real addresses to report (e.g. a health
+		// check, or a balancer proxying a non-TCP/unspecified source);
+		// mirrors how the v2 LOCAL command is handled below.
+		return nil, nil, nil
+	}
+	if len(fields) != 6 || fields[0] != "PROXY" {
+		return nil, nil, fmt.Errorf("malformed v1 header %q", line)
+	}
+
+	srcPort, err := strconv.Atoi(fields[4])
+	if err != nil {
+		return nil, nil, fmt.Errorf("malformed v1 source port: %s", err)
+	}
+	dstPort, err := strconv.Atoi(fields[5])
+	if err != nil {
+		return nil, nil, fmt.Errorf("malformed v1 dest port: %s", err)
+	}
+
+	srcIP := net.ParseIP(fields[2])
+	dstIP := net.ParseIP(fields[3])
+	if srcIP == nil || dstIP == nil {
+		return nil, nil, fmt.Errorf("malformed v1 address in %q", line)
+	}
+
+	return &net.TCPAddr{IP: srcIP, Port: srcPort}, &net.TCPAddr{IP: dstIP, Port: dstPort}, nil
+}
+
+func readProxyHeaderV2(br *bufio.Reader) (src, dst *net.TCPAddr, err error) {
+	hdr := make([]byte, 16)
+	if _, err := io.ReadFull(br, hdr); err != nil {
+		return nil, nil, fmt.Errorf("reading v2 header: %s", err)
+	}
+
+	length := int(binary.BigEndian.Uint16(hdr[14:16]))
+	body := make([]byte, length)
+	if _, err := io.ReadFull(br, body); err != nil {
+		return nil, nil, fmt.Errorf("reading v2 body: %s", err)
+	}
+
+	command := hdr[12] & 0x0F
+	if command == 0x00 { // LOCAL: health check, no real addresses
+		return nil, nil, nil
+	}
+
+	family := hdr[13] >> 4
+	switch family {
+	case 0x1: // AF_INET
+		if len(body) < 12 {
+			return nil, nil, fmt.Errorf("v2 body too short for AF_INET")
+		}
+		srcIP := net.IP(body[0:4])
+		dstIP := net.IP(body[4:8])
+		srcPort := binary.BigEndian.Uint16(body[8:10])
+		dstPort := binary.BigEndian.Uint16(body[10:12])
+		return &net.TCPAddr{IP: srcIP, Port: int(srcPort)}, &net.TCPAddr{IP: dstIP, Port: int(dstPort)}, nil
+	case 0x2: // AF_INET6
+		if len(body) < 36 {
+			return nil, nil, fmt.Errorf("v2 body too short for AF_INET6")
+		}
+		srcIP := net.IP(body[0:16])
+		dstIP := net.IP(body[16:32])
+		srcPort := binary.BigEndian.Uint16(body[32:34])
+		dstPort := binary.BigEndian.Uint16(body[34:36])
+		return &net.TCPAddr{IP: srcIP, Port: int(srcPort)}, &net.TCPAddr{IP: dstIP, Port: int(dstPort)}, nil
+	default:
+		return nil, nil, fmt.Errorf("unsupported v2 address family %#x", family)
+	}
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"net"
+	"strings"
+	"testing"
+)
+
+func TestReadProxyHeaderV1(t *testing.T) {
+	br := bufio.NewReader(strings.NewReader("PROXY TCP4 192.168.0.1 192.168.0.11 56324 443\r\nrest"))
+	src, dst, err := readProxyHeader(br)
+	if err != nil {
+		t.Fatalf("readProxyHeader: %s", err)
+	}
+	if src.String() != "192.168.0.1:56324" {
+		t.Errorf("src = %s, want 192.168.0.1:56324", src)
+	}
+	if dst.String() != "192.168.0.11:443" {
+		t.Errorf("dst = %s, want 192.168.0.11:443", dst)
+	}
+
+	rest, _ := br.ReadString(0)
+	if rest != "rest" {
+		t.Errorf("leftover bytes = %q, want %q", rest, "rest")
+	}
+}
+
+func TestReadProxyHeaderV1Unknown(t *testing.T) {
+	br := bufio.NewReader(strings.NewReader("PROXY UNKNOWN\r\nrest"))
+	src, dst, err := readProxyHeader(br)
+	if err != nil {
+		t.Fatalf("readProxyHeader: %s", err)
+	}
+	if src != nil || dst != nil {
+		t.Errorf("src, dst = %v, %v, want nil, nil", src, dst)
+	}
+
+	rest, _ := br.ReadString(0)
+	if rest != "rest" {
+		t.Errorf("leftover bytes = %q, want %q", rest, "rest")
+	}
+}
+
+func TestReadProxyHeaderV1Malformed(t *testing.T) {
+	cases := []string{
+		"PROXY TCP4 192.168.0.1 192.168.0.11 56324\r\n",    // missing a field
+		"NOTPROXY TCP4 1.1.1.1 2.2.2.2 1 2\r\n",            // wrong keyword
+		"PROXY TCP4 not-an-ip 192.168.0.11 56324 443\r\n",  // bad src IP
+		"PROXY TCP4 192.168.0.1 192.168.0.11 nope 443\r\n", // bad src port
+	}
+	for _, c := range cases {
+		br := bufio.NewReader(strings.NewReader(c))
+		if _, _, err := readProxyHeader(br); err == nil {
+			t.Errorf("readProxyHeader(%q): expected error, got nil", c)
+		}
+	}
+}
+
+func TestReadProxyHeaderV1Truncated(t *testing.T) {
+	br := bufio.NewReader(strings.NewReader("PROXY TCP4 192.168.0.1 192.168.0.11 56324 44"))
+	if _, _, err := readProxyHeader(br); err == nil {
+		t.Error("readProxyHeader: expected error on truncated line with no newline, got nil")
+	}
+}
+
+func TestWriteReadProxyHeaderV2Roundtrip(t *testing.T) {
+	src := mustTCPAddr(t, "10.0.0.1:12345")
+	dst := mustTCPAddr(t, "10.0.0.2:443")
+
+	header, err := writeProxyHeader(proxyProtoV2, src, dst, "example.com")
+	if err != nil {
+		t.Fatalf("writeProxyHeader: %s", err)
+	}
+
+	br := bufio.NewReader(bytes.NewReader(header))
+	gotSrc, gotDst, err := readProxyHeader(br)
+	if err != nil {
+		t.Fatalf("readProxyHeader: %s", err)
+	}
+	if gotSrc.String() != src.String() {
+		t.Errorf("src = %s, want %s", gotSrc, src)
+	}
+	if gotDst.String() != dst.String() {
+		t.Errorf("dst = %s, want %s", gotDst, dst)
+	}
+}
+
+func TestReadProxyHeaderV2TruncatedBody(t *testing.T) {
+	src := mustTCPAddr(t, "10.0.0.1:12345")
+	dst := mustTCPAddr(t, "10.0.0.2:443")
+	header, err := writeProxyHeader(proxyProtoV2, src, dst, "")
+	if err != nil {
+		t.Fatalf("writeProxyHeader: %s", err)
+	}
+
+	// Truncate the body, leaving the 16-byte fixed header (whose length
+	// field still claims the full body size) intact.
+	truncated := header[:20]
+	br := bufio.NewReader(bytes.NewReader(truncated))
+	if _, _, err := readProxyHeader(br); err == nil {
+		t.Error("readProxyHeader: expected error on truncated v2 body, got nil")
+	}
+}
+
+func mustTCPAddr(t *testing.T, s string) *net.TCPAddr {
+	t.Helper()
+	addr, err := net.ResolveTCPAddr("tcp", s)
+	if err != nil {
+		t.Fatalf("ResolveTCPAddr(%q): %s", s, err)
+	}
+	return addr
+}
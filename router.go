@@ -0,0 +1,60 @@
+package main
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// Router holds the routing table currently in effect and serves
+// Conn.proxy's lookups against it. The table itself is an immutable
+// *Config; reloading swaps in a freshly built one with a single
+// atomic store, so a Conn.proxy call that already loaded the old
+// *Config keeps using it to completion undisturbed - there is
+// nothing to lock or drain on reload.
+type Router struct {
+	current atomic.Value // *Config
+}
+
+// newRouter returns a Router serving initial until the first reload.
+func newRouter(initial *Config) *Router {
+	r := &Router{}
+	r.current.Store(initial)
+	return r
+}
+
+// Match returns the best route for hostname and alpnProtos under the
+// routing table currently in effect, or nil if none matches.
+func (r *Router) Match(hostname string, alpnProtos []string) *Route {
+	return r.current.Load().(*Config).Match(hostname, alpnProtos)
+}
+
+// Snapshot returns the routing table currently in effect, for the
+// admin /routes endpoint.
+func (r *Router) Snapshot() []RouteInfo {
+	return r.current.Load().(*Config).Snapshot()
+}
+
+// set installs c as the routing table in effect. It's called by the
+// config sources in configsource.go as they notice changes.
+func (r *Router) set(c *Config) {
+	r.current.Store(c)
+}
+
+// allBackends returns every Backend in the routing table currently in
+// effect, across all routes.
+func (r *Router) allBackends() []*Backend {
+	return r.current.Load().(*Config).allBackends()
+}
+
+// StartHealthChecks launches a background goroutine that periodically
+// probes every backend in whichever routing table is currently in
+// effect, so reloads are picked up without restarting the checker. It
+// never returns.
+func (r *Router) StartHealthChecks(opts healthCheckOpts) {
+	go func() {
+		for {
+			probeAll(r.allBackends(), opts)
+			time.Sleep(opts.interval)
+		}
+	}()
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// extractClientHello reads a TLS ClientHello from r and returns the
+// requested server name (SNI), the client's offered ALPN protocols in
+// the order it sent them, and the minor version of the TLS record
+// layer (0 for SSLv3, 1 for TLS 1.0, ...). It does not consume more of
+// r than the single handshake record containing the ClientHello.
+func extractClientHello(r io.Reader) (hostname string, alpnProtos []string, tlsMinor int, err error) {
+	br := bufio.NewReader(r)
+
+	hdr, err := readN(br, 5)
+	if err != nil {
+		return "", nil, 0, fmt.Errorf("reading record header: %s", err)
+	}
+	if hdr[0] != recordTypeHandshake {
+		return "", nil, 0, fmt.Errorf("not a handshake record (type %d)", hdr[0])
+	}
+	tlsMinor = int(hdr[2])
+	recordLen := int(binary.BigEndian.Uint16(hdr[3:5]))
+
+	record, err := readN(br, recordLen)
+	if err != nil {
+		return "", nil, 0, fmt.Errorf("reading handshake record: %s", err)
+	}
+	if len(record) < 4 || record[0] != handshakeTypeClientHello {
+		return "", nil, 0, fmt.Errorf("not a ClientHello")
+	}
+
+	hostname, alpnProtos, err = parseClientHello(record[4:])
+	if err != nil {
+		return "", nil, 0, err
+	}
+	return hostname, alpnProtos, tlsMinor, nil
+}
+
+// parseClientHello walks the body of a ClientHello handshake message
+// (everything after the 4-byte handshake header) and extracts the
+// server_name and application_layer_protocol_negotiation extensions,
+// if present.
+func parseClientHello(body []byte) (hostname string, alpnProtos []string, err error) {
+	// client_version(2) + random(32)
+	if len(body) < 34 {
+		return "", nil, fmt.Errorf("ClientHello too short")
+	}
+	b := body[34:]
+
+	// session_id
+	b, err = skipLenPrefixed(b, 1)
+	if err != nil {
+		return "", nil, fmt.Errorf("session id: %s", err)
+	}
+
+	// cipher_suites
+	b, err = skipLenPrefixed(b, 2)
+	if err != nil {
+		return "", nil, fmt.Errorf("cipher suites: %s", err)
+	}
+
+	// compression_methods
+	b, err = skipLenPrefixed(b, 1)
+	if err != nil {
+		return "", nil, fmt.Errorf("compression methods: %s", err)
+	}
+
+	if len(b) == 0 {
+		// No extensions; ClientHello may still be valid, just with no
+		// SNI or ALPN to route on.
+		return "", nil, nil
+	}
+	if len(b) < 2 {
+		return "", nil, fmt.Errorf("extensions: truncated length")
+	}
+	extLen := int(binary.BigEndian.Uint16(b[0:2]))
+	b = b[2:]
+	if len(b) < extLen {
+		return "", nil, fmt.Errorf("extensions: truncated body")
+	}
+	extensions := b[:extLen]
+
+	for len(extensions) > 0 {
+		if len(extensions) < 4 {
+			return "", nil, fmt.Errorf("extension header truncated")
+		}
+		extType := binary.BigEndian.Uint16(extensions[0:2])
+		length := int(binary.BigEndian.Uint16(extensions[2:4]))
+		extensions = extensions[4:]
+		if len(extensions) < length {
+			return "", nil, fmt.Errorf("extension body truncated")
+		}
+		data := extensions[:length]
+		extensions = extensions[length:]
+
+		switch extType {
+		case extensionServerName:
+			hostname, err = parseServerNameExtension(data)
+			if err != nil {
+				return "", nil, fmt.Errorf("server_name extension: %s", err)
+			}
+		case extensionALPN:
+			alpnProtos, err = parseALPNExtension(data)
+			if err != nil {
+				return "", nil, fmt.Errorf("alpn extension: %s", err)
+			}
+		}
+	}
+
+	return hostname, alpnProtos, nil
+}
+
+// parseServerNameExtension parses the body of a server_name extension
+// and returns the first host_name entry it contains.
+func parseServerNameExtension(data []byte) (string, error) {
+	if len(data) < 2 {
+		return "", fmt.Errorf("truncated list length")
+	}
+	listLen := int(binary.BigEndian.Uint16(data[0:2]))
+	data = data[2:]
+	if len(data) < listLen {
+		return "", fmt.Errorf("truncated list")
+	}
+	data = data[:listLen]
+
+	for len(data) > 0 {
+		if len(data) < 3 {
+			return "", fmt.Errorf("truncated entry header")
+		}
+		nameType := data[0]
+		nameLen := int(binary.BigEndian.Uint16(data[1:3]))
+		data = data[3:]
+		if len(data) < nameLen {
+			return "", fmt.Errorf("truncated entry")
+		}
+		name := data[:nameLen]
+		data = data[nameLen:]
+
+		if nameType == serverNameTypeHostname {
+			return string(name), nil
+		}
+	}
+	return "", nil
+}
+
+// parseALPNExtension parses the body of an
+// application_layer_protocol_negotiation extension and returns the
+// client's offered protocol names, in the order it sent them.
+func parseALPNExtension(data []byte) ([]string, error) {
+	if len(data) < 2 {
+		return nil, fmt.Errorf("truncated list length")
+	}
+	listLen := int(binary.BigEndian.Uint16(data[0:2]))
+	data = data[2:]
+	if len(data) < listLen {
+		return nil, fmt.Errorf("truncated list")
+	}
+	data = data[:listLen]
+
+	var protos []string
+	for len(data) > 0 {
+		nameLen := int(data[0])
+		data = data[1:]
+		if len(data) < nameLen {
+			return nil, fmt.Errorf("truncated entry")
+		}
+		protos = append(protos, string(data[:nameLen]))
+		data = data[nameLen:]
+	}
+	return protos, nil
+}
+
+// skipLenPrefixed consumes a length-prefixed field (the length is
+// lenBytes wide, big-endian) from the front of b and returns the
+// remainder.
+func skipLenPrefixed(b []byte, lenBytes int) ([]byte, error) {
+	if len(b) < lenBytes {
+		return nil, fmt.Errorf("truncated length")
+	}
+	var n int
+	for i := 0; i < lenBytes; i++ {
+		n = n<<8 | int(b[i])
+	}
+	b = b[lenBytes:]
+	if len(b) < n {
+		return nil, fmt.Errorf("truncated value")
+	}
+	return b[n:], nil
+}
+
+func readN(r io.Reader, n int) ([]byte, error) {
+	buf := make([]byte, n)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return nil, err
+	}
+	return buf, nil
+}
+
+const (
+	recordTypeHandshake      = 22
+	handshakeTypeClientHello = 1
+	extensionServerName      = 0
+	extensionALPN            = 16
+	serverNameTypeHostname   = 0
+)
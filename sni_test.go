@@ -0,0 +1,122 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+// encodeALPNExtension builds the body of an ALPN extension offering
+// protos, for feeding into parseALPNExtension.
+func encodeALPNExtension(protos []string) []byte {
+	var list bytes.Buffer
+	for _, p := range protos {
+		list.WriteByte(byte(len(p)))
+		list.WriteString(p)
+	}
+
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.BigEndian, uint16(list.Len()))
+	buf.Write(list.Bytes())
+	return buf.Bytes()
+}
+
+func TestParseALPNExtension(t *testing.T) {
+	data := encodeALPNExtension([]string{"h2", "http/1.1"})
+	protos, err := parseALPNExtension(data)
+	if err != nil {
+		t.Fatalf("parseALPNExtension: %s", err)
+	}
+	if len(protos) != 2 || protos[0] != "h2" || protos[1] != "http/1.1" {
+		t.Errorf("protos = %v, want [h2 http/1.1]", protos)
+	}
+}
+
+func TestParseALPNExtensionEmpty(t *testing.T) {
+	protos, err := parseALPNExtension(encodeALPNExtension(nil))
+	if err != nil {
+		t.Fatalf("parseALPNExtension: %s", err)
+	}
+	if len(protos) != 0 {
+		t.Errorf("protos = %v, want none", protos)
+	}
+}
+
+func TestParseALPNExtensionTruncated(t *testing.T) {
+	cases := [][]byte{
+		{},                           // no list length at all
+		{0x00},                       // list length truncated
+		{0x00, 0x05, 0x02, 'h', '2'}, // list length claims 5 bytes, only 3 present
+		{0x00, 0x03, 0x05, 'h', '2'}, // entry length claims 5, only 2 bytes remain
+	}
+	for _, c := range cases {
+		if _, err := parseALPNExtension(c); err == nil {
+			t.Errorf("parseALPNExtension(%x): expected error, got nil", c)
+		}
+	}
+}
+
+// buildClientHelloBody assembles a minimal ClientHello handshake body
+// (everything after the 4-byte handshake header) offering hostname via
+// SNI and protos via ALPN, for exercising parseClientHello end to end.
+func buildClientHelloBody(hostname string, protos []string) []byte {
+	var body bytes.Buffer
+	body.Write(make([]byte, 2))  // client_version
+	body.Write(make([]byte, 32)) // random
+	body.WriteByte(0)            // session_id: empty
+	binary.Write(&body, binary.BigEndian, uint16(2))
+	body.Write([]byte{0x00, 0x00}) // cipher_suites: one suite
+	body.WriteByte(1)              // compression_methods length
+	body.WriteByte(0)              // null compression
+
+	var extensions bytes.Buffer
+	if hostname != "" {
+		var names bytes.Buffer
+		names.WriteByte(serverNameTypeHostname)
+		binary.Write(&names, binary.BigEndian, uint16(len(hostname)))
+		names.WriteString(hostname)
+
+		var ext bytes.Buffer
+		binary.Write(&ext, binary.BigEndian, uint16(names.Len()))
+		ext.Write(names.Bytes())
+
+		binary.Write(&extensions, binary.BigEndian, uint16(extensionServerName))
+		binary.Write(&extensions, binary.BigEndian, uint16(ext.Len()))
+		extensions.Write(ext.Bytes())
+	}
+	if protos != nil {
+		alpn := encodeALPNExtension(protos)
+		binary.Write(&extensions, binary.BigEndian, uint16(extensionALPN))
+		binary.Write(&extensions, binary.BigEndian, uint16(len(alpn)))
+		extensions.Write(alpn)
+	}
+
+	binary.Write(&body, binary.BigEndian, uint16(extensions.Len()))
+	body.Write(extensions.Bytes())
+	return body.Bytes()
+}
+
+func TestParseClientHelloSNIAndALPN(t *testing.T) {
+	body := buildClientHelloBody("example.com", []string{"h2", "http/1.1"})
+	hostname, protos, err := parseClientHello(body)
+	if err != nil {
+		t.Fatalf("parseClientHello: %s", err)
+	}
+	if hostname != "example.com" {
+		t.Errorf("hostname = %q, want %q", hostname, "example.com")
+	}
+	if len(protos) != 2 || protos[0] != "h2" || protos[1] != "http/1.1" {
+		t.Errorf("protos = %v, want [h2 http/1.1]", protos)
+	}
+}
+
+func TestParseClientHelloNoExtensions(t *testing.T) {
+	body := buildClientHelloBody("", nil)
+	hostname, protos, err := parseClientHello(body)
+	if err != nil {
+		t.Fatalf("parseClientHello: %s", err)
+	}
+	if hostname != "" || protos != nil {
+		t.Errorf("hostname, protos = %q, %v, want empty", hostname, protos)
+	}
+}
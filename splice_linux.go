@@ -0,0 +1,119 @@
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"io"
+	"net"
+	"syscall"
+)
+
+// fSetPipeSz is Linux's F_SETPIPE_SZ fcntl command (1031). It isn't
+// defined by the syscall package on every architecture, so it's
+// hardcoded here rather than imported.
+const fSetPipeSz = 1031
+
+// SPLICE_F_MOVE and SPLICE_F_NONBLOCK aren't defined by the standard
+// syscall package (only golang.org/x/sys/unix has them, which this
+// dependency-free module doesn't vendor), so they're hardcoded here
+// from the kernel UAPI values instead.
+const (
+	spliceFMove     = 0x1
+	spliceFNonblock = 0x2
+)
+
+// pump moves bytes from src to dst using splice(2) through an
+// intermediate pipe, so the kernel never copies the payload into our
+// userspace buffers. It falls back to io.Copy (which itself still
+// gets splice for free via (*net.TCPConn).ReadFrom) if splice turns
+// out not to be usable for this fd pair at all.
+func pump(dst, src *net.TCPConn) (int64, error) {
+	srcRaw, err := src.SyscallConn()
+	if err != nil {
+		return io.Copy(dst, src)
+	}
+	dstRaw, err := dst.SyscallConn()
+	if err != nil {
+		return io.Copy(dst, src)
+	}
+
+	prFD, pwFD, err := newSplicePipe(*splicePipeSize)
+	if err != nil {
+		return io.Copy(dst, src)
+	}
+	defer syscall.Close(prFD)
+	defer syscall.Close(pwFD)
+
+	var total int64
+	for {
+		nread, rerr := spliceInto(srcRaw, pwFD)
+		if rerr == syscall.EINVAL && total == 0 {
+			// splice can't move bytes between these two fds at all
+			// (e.g. one of them isn't a plain TCP socket); let the
+			// generic copy path take the whole transfer from here.
+			n, err := io.Copy(dst, src)
+			return total + n, err
+		}
+		if rerr != nil {
+			return total, rerr
+		}
+		if nread == 0 {
+			return total, nil // EOF on src
+		}
+
+		for nread > 0 {
+			nwritten, werr := spliceFrom(dstRaw, prFD, nread)
+			if werr != nil {
+				return total, werr
+			}
+			nread -= nwritten
+			total += nwritten
+		}
+	}
+}
+
+// newSplicePipe creates a pipe to use as splice's intermediate
+// buffer, sized to pipeSize bytes if pipeSize > 0 (via F_SETPIPE_SZ),
+// or left at the kernel default otherwise.
+func newSplicePipe(pipeSize int) (r, w int, err error) {
+	var fds [2]int
+	if err := syscall.Pipe2(fds[:], syscall.O_NONBLOCK); err != nil {
+		return 0, 0, err
+	}
+	if pipeSize > 0 {
+		if _, _, errno := syscall.Syscall(syscall.SYS_FCNTL, uintptr(fds[1]), fSetPipeSz, uintptr(pipeSize)); errno != 0 {
+			syscall.Close(fds[0])
+			syscall.Close(fds[1])
+			return 0, 0, errno
+		}
+	}
+	return fds[0], fds[1], nil
+}
+
+// spliceInto reads up to 1MB from srcRaw into the pipe write end
+// pwFD, blocking (via the runtime poller) until data is available.
+func spliceInto(srcRaw syscall.RawConn, pwFD int) (n int64, err error) {
+	cbErr := srcRaw.Read(func(srcFD uintptr) bool {
+		n, err = syscall.Splice(int(srcFD), nil, pwFD, nil, 1<<20, spliceFMove|spliceFNonblock)
+		return err != syscall.EAGAIN
+	})
+	if cbErr != nil {
+		return n, cbErr
+	}
+	return n, err
+}
+
+// spliceFrom drains up to max bytes from the pipe read end prFD into
+// dstRaw, blocking (via the runtime poller) until the destination is
+// writable.
+func spliceFrom(dstRaw syscall.RawConn, prFD int, max int64) (n int64, err error) {
+	cbErr := dstRaw.Write(func(dstFD uintptr) bool {
+		n, err = syscall.Splice(prFD, nil, int(dstFD), nil, int(max), spliceFMove|spliceFNonblock)
+		return err != syscall.EAGAIN
+	})
+	if cbErr != nil {
+		return n, cbErr
+	}
+	return n, err
+}
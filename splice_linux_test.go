@@ -0,0 +1,96 @@
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"io"
+	"net"
+	"testing"
+)
+
+// loopbackPair returns two ends of a TCP connection over the loopback
+// interface, for benchmarking pump against real sockets rather than
+// pipes (splice(2) needs the fd to be backed by a socket or pipe, not
+// an in-memory net.Pipe).
+func loopbackPair(tb testing.TB) (client, server *net.TCPConn) {
+	tb.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		tb.Fatalf("listen: %s", err)
+	}
+	defer l.Close()
+
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		c, err := l.Accept()
+		if err != nil {
+			tb.Errorf("accept: %s", err)
+			return
+		}
+		accepted <- c
+	}()
+
+	c, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		tb.Fatalf("dial: %s", err)
+	}
+	return c.(*net.TCPConn), (<-accepted).(*net.TCPConn)
+}
+
+// benchmarkThroughput wires up a writer, a "backend" src/dst pair
+// joined by copy, and a reader, then streams n bytes end to end -
+// mirroring how Conn.proxy uses pump to move bytes between the client
+// and the backend.
+func benchmarkThroughput(b *testing.B, n int64, copy func(dst, src *net.TCPConn) (int64, error)) {
+	payload := make([]byte, 1<<20)
+
+	for i := 0; i < b.N; i++ {
+		writer, src := loopbackPair(b)
+		dst, reader := loopbackPair(b)
+		b.SetBytes(n)
+
+		copyDone := make(chan error, 1)
+		go func() {
+			_, err := copy(dst, src)
+			dst.CloseWrite()
+			copyDone <- err
+		}()
+
+		go func() {
+			var written int64
+			for written < n {
+				chunk := payload
+				if remaining := n - written; remaining < int64(len(chunk)) {
+					chunk = chunk[:remaining]
+				}
+				nw, err := writer.Write(chunk)
+				written += int64(nw)
+				if err != nil {
+					break
+				}
+			}
+			writer.Close()
+		}()
+
+		io.Copy(io.Discard, reader)
+		<-copyDone
+		src.Close()
+		dst.Close()
+		reader.Close()
+	}
+}
+
+// BenchmarkPumpSplice measures pump's splice(2) fast path moving a
+// large TLS-stream-sized payload between two loopback sockets.
+func BenchmarkPumpSplice(b *testing.B) {
+	benchmarkThroughput(b, 64<<20, pump)
+}
+
+// BenchmarkPumpIOCopy is the same benchmark against plain io.Copy, to
+// compare against the splice(2) fast path above.
+func BenchmarkPumpIOCopy(b *testing.B) {
+	benchmarkThroughput(b, 64<<20, func(dst, src *net.TCPConn) (int64, error) {
+		return io.Copy(dst, src)
+	})
+}
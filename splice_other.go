@@ -0,0 +1,17 @@
+//go:build !linux
+// +build !linux
+
+package main
+
+import (
+	"io"
+	"net"
+)
+
+// pump moves bytes from src to dst. splice(2) is Linux-only; on other
+// platforms we rely on (*net.TCPConn).ReadFrom, which picks the best
+// available zero-copy mechanism itself (e.g. sendfile on some BSDs),
+// falling back to a generic copy otherwise.
+func pump(dst, src *net.TCPConn) (int64, error) {
+	return io.Copy(dst, src)
+}